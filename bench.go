@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	bench              = flag.Bool("bench", false, "run in load-testing mode against -urls instead of showing the UI")
+	benchThreads       = flag.Int("bench.rthreads", 10, "number of concurrent workers hammering -urls in -bench mode")
+	benchDuration      = flag.Duration("bench.duration", 30*time.Second, "how long to run -bench mode for")
+	benchStatsInterval = flag.Duration("bench.stats-interval", 5*time.Second, "how often -bench mode reports stats to stderr")
+)
+
+// benchStats accumulates results from concurrent bench workers. All counters
+// are updated atomically; latencies are collected under mu since percentiles
+// need the full sorted sample.
+type benchStats struct {
+	requests int64
+	errors   int64
+	bytes    int64
+
+	mu        sync.Mutex
+	latencies []time.Duration
+}
+
+func (bs *benchStats) record(d time.Duration, n int, err error) {
+	atomic.AddInt64(&bs.requests, 1)
+	atomic.AddInt64(&bs.bytes, int64(n))
+	if err != nil {
+		atomic.AddInt64(&bs.errors, 1)
+	}
+
+	bs.mu.Lock()
+	bs.latencies = append(bs.latencies, d)
+	bs.mu.Unlock()
+}
+
+// snapshot returns p50/p95/p99 latency over everything recorded since the
+// last snapshot, plus cumulative requests/errors/bytes, and clears the
+// latency sample so each report covers only its own interval.
+func (bs *benchStats) snapshot() (p50, p95, p99 time.Duration, requests, errors, bytes int64) {
+	bs.mu.Lock()
+	latencies := bs.latencies
+	bs.latencies = nil
+	bs.mu.Unlock()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	pick := func(p float64) time.Duration {
+		if len(latencies) == 0 {
+			return 0
+		}
+		idx := int(p * float64(len(latencies)-1))
+		return latencies[idx]
+	}
+
+	return pick(0.50), pick(0.95), pick(0.99),
+		atomic.LoadInt64(&bs.requests), atomic.LoadInt64(&bs.errors), atomic.LoadInt64(&bs.bytes)
+}
+
+// RunBench repeatedly calls FetchExpvar and Service.Update against urls
+// using -bench.rthreads concurrent workers for -bench.duration, reporting
+// throughput and latency percentiles to stderr every -bench.stats-interval.
+// It reuses Service so the guessValue path is exercised exactly as it is in
+// normal polling.
+//
+// This package does not ship a main.go, so nothing calls RunBench yet; the
+// -bench flag it reads is declared but never dispatched on. Wiring this into
+// an actual entrypoint (checking *bench right after flag.Parse and calling
+// RunBench with the parsed -urls/-vars instead of entering the normal
+// polling-loop-plus-TUI startup path) is a blocking follow-up, not done
+// here.
+func RunBench(urls []url.URL, vars []VarName) {
+	if len(urls) == 0 {
+		fmt.Fprintln(os.Stderr, "bench: no -urls configured")
+		return
+	}
+
+	outputSinks, err := NewSinks(*output, tag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bench: -output: %v\n", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *benchDuration)
+	defer cancel()
+
+	stats := &benchStats{}
+	var wg sync.WaitGroup
+
+	for i := 0; i < *benchThreads; i++ {
+		svc := NewService(urls[i%len(urls)], vars, outputSinks)
+		wg.Add(1)
+		go benchWorker(ctx, svc, vars, stats, &wg)
+	}
+
+	reportDone := make(chan struct{})
+	go benchReport(ctx, stats, reportDone)
+
+	wg.Wait()
+	<-reportDone
+
+	// Every worker's Update goroutine has stopped by now (wg.Wait() above),
+	// so it's safe to close the output sinks they all shared.
+	for _, sink := range outputSinks {
+		if err := sink.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "bench: sink close failed: %v\n", err)
+		}
+	}
+}
+
+func benchWorker(ctx context.Context, svc *Service, vars []VarName, stats *benchStats, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		start := time.Now()
+
+		var updateWG sync.WaitGroup
+		updateWG.Add(1)
+		svc.Update(ctx, &updateWG)
+		updateWG.Wait()
+
+		// The bytes/sec figure approximates payload size from the sampled
+		// values rather than re-fetching: Service.Update doesn't expose the
+		// raw response size, and a second HTTP call would skew req/sec.
+		n := 0
+		for _, v := range vars {
+			n += len(svc.Value(v))
+		}
+
+		stats.record(time.Since(start), n, svc.Err)
+	}
+}
+
+func benchReport(ctx context.Context, stats *benchStats, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(*benchStatsInterval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+			printBenchStats(stats, time.Since(start))
+		case <-ctx.Done():
+			printBenchStats(stats, time.Since(start))
+			return
+		}
+	}
+}
+
+func printBenchStats(stats *benchStats, elapsed time.Duration) {
+	p50, p95, p99, requests, errors, bytes := stats.snapshot()
+	secs := elapsed.Seconds()
+	if secs == 0 {
+		secs = 1
+	}
+
+	var errRate float64
+	if requests > 0 {
+		errRate = float64(errors) / float64(requests) * 100
+	}
+
+	fmt.Fprintf(os.Stderr,
+		"bench: %6.1fs  req/s=%-8.1f bytes/s=%-10.1f err%%=%-6.2f p50=%-10s p95=%-10s p99=%s\n",
+		elapsed.Seconds(), float64(requests)/secs, float64(bytes)/secs, errRate, p50, p95, p99)
+}
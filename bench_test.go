@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBenchStatsSnapshotPercentiles(t *testing.T) {
+	bs := &benchStats{}
+
+	for i := 1; i <= 100; i++ {
+		var err error
+		if i%10 == 0 {
+			err = errTestBench
+		}
+		bs.record(time.Duration(i)*time.Millisecond, 128, err)
+	}
+
+	p50, p95, p99, requests, errors, bytes := bs.snapshot()
+
+	if requests != 100 {
+		t.Errorf("requests = %d, want 100", requests)
+	}
+	if errors != 10 {
+		t.Errorf("errors = %d, want 10", errors)
+	}
+	if bytes != 100*128 {
+		t.Errorf("bytes = %d, want %d", bytes, 100*128)
+	}
+	if p50 != 50*time.Millisecond {
+		t.Errorf("p50 = %s, want 50ms", p50)
+	}
+	if p95 != 95*time.Millisecond {
+		t.Errorf("p95 = %s, want 95ms", p95)
+	}
+	if p99 != 99*time.Millisecond {
+		t.Errorf("p99 = %s, want 99ms", p99)
+	}
+}
+
+func TestBenchStatsSnapshotClearsLatenciesButKeepsCumulativeCounters(t *testing.T) {
+	bs := &benchStats{}
+	bs.record(10*time.Millisecond, 1, nil)
+
+	p50, _, _, requests, _, _ := bs.snapshot()
+	if p50 != 10*time.Millisecond {
+		t.Fatalf("p50 = %s, want 10ms", p50)
+	}
+	if requests != 1 {
+		t.Fatalf("requests = %d, want 1", requests)
+	}
+
+	// A snapshot with nothing new recorded should report no latency sample
+	// but keep the cumulative request count.
+	p50, _, _, requests, _, _ = bs.snapshot()
+	if p50 != 0 {
+		t.Errorf("p50 after empty interval = %s, want 0", p50)
+	}
+	if requests != 1 {
+		t.Errorf("requests after empty interval = %d, want 1 (cumulative)", requests)
+	}
+}
+
+var errTestBench = &testBenchError{}
+
+type testBenchError struct{}
+
+func (*testBenchError) Error() string { return "bench test error" }
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var httpListen = flag.String("http.listen", "", "address for the optional HTTP control API, e.g. :9999 (empty disables it)")
+
+// Aggregate scrape stats, exposed as expvar vars on the control API's
+// /debug/vars endpoint so expvarmon can be pointed at itself.
+var (
+	scrapeSuccesses = expvar.NewInt("expvarmon.scrapes.success")
+	scrapeFailures  = expvar.NewInt("expvarmon.scrapes.failure")
+	serviceRestarts = expvar.NewInt("expvarmon.restarts")
+)
+
+// ControlAPI is the optional -http.listen server exposing per-service stats
+// and a reset endpoint. It's safe for concurrent access: services is guarded
+// by mu, and each *Service guards its own stacks with its own mutex.
+//
+// This package does not ship a main.go, so nothing currently builds a
+// ControlAPI, Tracks a Service with it, or calls ListenAndServe: -http.listen
+// is read but never actually serves anything. Wiring one up (building it
+// with NewControlAPI, Track()ing the *Services the polling loop constructs,
+// and running ListenAndServe in its own goroutine alongside that loop and
+// the optional TUI) is a blocking follow-up, not done here.
+type ControlAPI struct {
+	mu       sync.RWMutex
+	services map[string]*Service
+}
+
+// NewControlAPI returns an empty ControlAPI ready to have services added
+// via Track.
+func NewControlAPI() *ControlAPI {
+	return &ControlAPI{services: make(map[string]*Service)}
+}
+
+// Track registers services with the API so they show up in its endpoints.
+func (api *ControlAPI) Track(services []*Service) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	for _, s := range services {
+		api.services[s.Name] = s
+	}
+}
+
+func (api *ControlAPI) lookup(name string) (*Service, bool) {
+	api.mu.RLock()
+	defer api.mu.RUnlock()
+	s, ok := api.services[name]
+	return s, ok
+}
+
+// ListenAndServe starts the control API on *httpListen. It's a no-op if
+// -http.listen wasn't set.
+func (api *ControlAPI) ListenAndServe() error {
+	if *httpListen == "" {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/services", api.handleServices)
+	mux.HandleFunc("/services/", api.handleService)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	return http.ListenAndServe(*httpListen, mux)
+}
+
+type serviceSummary struct {
+	Name          string
+	URL           string
+	Cmdline       string
+	UptimeCounter int64
+	Err           string
+	LastPoll      time.Time
+}
+
+func (s *Service) summary() serviceSummary {
+	info := s.Info()
+
+	sum := serviceSummary{
+		Name:          info.Name,
+		URL:           s.URL.String(), // set once in NewService, never mutated
+		Cmdline:       info.Cmdline,
+		UptimeCounter: info.UptimeCounter,
+		LastPoll:      s.LastPoll(),
+	}
+	if info.Err != nil {
+		sum.Err = info.Err.Error()
+	}
+	return sum
+}
+
+// GET /services
+func (api *ControlAPI) handleServices(w http.ResponseWriter, r *http.Request) {
+	api.mu.RLock()
+	list := make([]serviceSummary, 0, len(api.services))
+	for _, s := range api.services {
+		list = append(list, s.summary())
+	}
+	api.mu.RUnlock()
+
+	writeJSON(w, list)
+}
+
+// /services/{name}/vars, /services/{name}/vars/{var}, /services/{name}/reset
+func (api *ControlAPI) handleService(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/services/"), "/"), "/")
+	if len(parts) < 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	svc, ok := api.lookup(parts[0])
+	if !ok {
+		http.Error(w, fmt.Sprintf("no such service %q", parts[0]), http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case len(parts) == 2 && parts[1] == "vars":
+		api.handleVars(w, svc)
+	case len(parts) == 3 && parts[1] == "vars":
+		api.handleVar(w, svc, VarName(parts[2]))
+	case len(parts) == 2 && parts[1] == "reset" && r.Method == http.MethodPost:
+		svc.Reset()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+type varSummary struct {
+	Value   string
+	Max     interface{}
+	History []int
+}
+
+// GET /services/{name}/vars
+func (api *ControlAPI) handleVars(w http.ResponseWriter, svc *Service) {
+	out := make(map[VarName]varSummary, len(svc.vars))
+	for _, name := range svc.vars {
+		out[name] = varSummary{
+			Value:   svc.Value(name),
+			Max:     svc.Max(name),
+			History: svc.Values(name),
+		}
+	}
+	writeJSON(w, out)
+}
+
+// varTimeSeries is the JSON response for GET /services/{name}/vars/{var}: the
+// var's current value and max alongside its recorded history as an actual
+// (time, value) time-series, rather than varSummary's bare History []int.
+type varTimeSeries struct {
+	Value  string
+	Max    interface{}
+	Points []TimeSeriesPoint
+}
+
+// GET /services/{name}/vars/{var}
+func (api *ControlAPI) handleVar(w http.ResponseWriter, svc *Service, name VarName) {
+	writeJSON(w, varTimeSeries{
+		Value:  svc.Value(name),
+		Max:    svc.Max(name),
+		Points: svc.TimeSeries(name),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
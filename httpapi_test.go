@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestControlAPIConcurrentTrackAndRead(t *testing.T) {
+	api := NewControlAPI()
+	svc := &Service{
+		Name:   "svc0",
+		URL:    url.URL{Host: "localhost:1234"},
+		vars:   []VarName{"foo"},
+		stacks: map[VarName]*Stack{"foo": NewStack()},
+	}
+	api.Track([]*Service{svc})
+
+	stop := make(chan struct{})
+
+	// Simulate an Update goroutine mutating the same fields handleServices
+	// and handleVars read, the way Service.Update does under s.mu.
+	var writer sync.WaitGroup
+	writer.Add(1)
+	go func() {
+		defer writer.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			svc.mu.Lock()
+			svc.UptimeCounter++
+			svc.stacks["foo"].Push(int64(i))
+			svc.lastPoll = time.Now()
+			svc.mu.Unlock()
+		}
+	}()
+
+	var readers sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for j := 0; j < 200; j++ {
+				api.Track([]*Service{svc})
+
+				rec := httptest.NewRecorder()
+				api.handleServices(rec, httptest.NewRequest(http.MethodGet, "/services", nil))
+				if rec.Code != http.StatusOK {
+					t.Errorf("handleServices: status = %d, want %d", rec.Code, http.StatusOK)
+				}
+
+				rec = httptest.NewRecorder()
+				api.handleVars(rec, svc)
+				if rec.Code != http.StatusOK {
+					t.Errorf("handleVars: status = %d, want %d", rec.Code, http.StatusOK)
+				}
+			}
+		}()
+	}
+
+	readers.Wait()
+	close(stop)
+	writer.Wait()
+}
+
+func TestHandleVarReturnsTimeSeries(t *testing.T) {
+	svc := &Service{
+		Name:   "svc0",
+		vars:   []VarName{"foo"},
+		stacks: map[VarName]*Stack{"foo": NewStack()},
+	}
+
+	for _, v := range []int64{1, 2, 3} {
+		svc.mu.Lock()
+		svc.stacks["foo"].Push(v)
+		svc.lastPoll = time.Now()
+		svc.pollTimes = append(svc.pollTimes, svc.lastPoll)
+		svc.mu.Unlock()
+	}
+
+	api := NewControlAPI()
+	rec := httptest.NewRecorder()
+	api.handleVar(rec, svc, "foo")
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("handleVar: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var got varTimeSeries
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+
+	if len(got.Points) != 3 {
+		t.Fatalf("len(Points) = %d, want 3", len(got.Points))
+	}
+	for i, p := range got.Points {
+		if p.Time.IsZero() {
+			t.Errorf("Points[%d].Time is zero, want a real poll timestamp", i)
+		}
+	}
+}
+
+func TestHandleServiceRoutesByPath(t *testing.T) {
+	api := NewControlAPI()
+	svc := &Service{
+		Name:   "svc0",
+		vars:   []VarName{"foo"},
+		stacks: map[VarName]*Stack{"foo": NewStack()},
+	}
+	api.Track([]*Service{svc})
+
+	cases := []struct {
+		path       string
+		method     string
+		wantStatus int
+	}{
+		{"/services/svc0/vars", http.MethodGet, http.StatusOK},
+		{"/services/svc0/vars/foo", http.MethodGet, http.StatusOK},
+		{"/services/svc0/reset", http.MethodPost, http.StatusNoContent},
+		{"/services/missing/vars", http.MethodGet, http.StatusNotFound},
+		{"/services/svc0", http.MethodGet, http.StatusNotFound},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.path, nil)
+		rec := httptest.NewRecorder()
+		api.handleService(rec, req)
+		if rec.Code != c.wantStatus {
+			t.Errorf("%s %s: status = %d, want %d", c.method, c.path, rec.Code, c.wantStatus)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	serializeMaxSize  = flag.String("serialize.max-size", "", "rotate the serialized CSV once it exceeds this size, e.g. 100MB (0 or empty disables)")
+	serializeMaxAge   = flag.Duration("serialize.max-age", 0, "rotate the serialized CSV once it's older than this, e.g. 24h (0 disables)")
+	serializeMaxFiles = flag.Int("serialize.max-files", 0, "keep at most this many rotated CSV segments per service (0 disables the count limit; -serialize.max-age still prunes by age)")
+	serializeGzip     = flag.Bool("serialize.gzip", false, "gzip rotated CSV segments")
+)
+
+// retentionPolicyFromFlags builds a retentionPolicy from the
+// -serialize.max-* flags.
+func retentionPolicyFromFlags() (retentionPolicy, error) {
+	size, err := parseByteSize(*serializeMaxSize)
+	if err != nil {
+		return retentionPolicy{}, fmt.Errorf("serialize.max-size: %v", err)
+	}
+
+	return retentionPolicy{
+		maxSize:  size,
+		maxAge:   *serializeMaxAge,
+		maxFiles: *serializeMaxFiles,
+		gzip:     *serializeGzip,
+	}, nil
+}
+
+// parseByteSize parses sizes like "100MB", "2GB" or a plain byte count.
+// An empty string means "no limit".
+func parseByteSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	s = strings.ToUpper(strings.TrimSpace(s))
+	units := []struct {
+		suffix string
+		mult   int64
+	}{
+		{"KB", 1 << 10},
+		{"MB", 1 << 20},
+		{"GB", 1 << 30},
+	}
+
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			n, err := strconv.ParseFloat(strings.TrimSuffix(s, u.suffix), 64)
+			if err != nil {
+				return 0, err
+			}
+			return int64(n * float64(u.mult)), nil
+		}
+	}
+
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// retentionPolicy bounds how much disk a rotatingWriter is allowed to use,
+// configured via -serialize.max-size, -serialize.max-age and
+// -serialize.max-files.
+type retentionPolicy struct {
+	maxSize  int64         // rotate once the current segment exceeds this many bytes, 0 disables
+	maxAge   time.Duration // rotate once the current segment is older than this, 0 disables
+	maxFiles int           // delete rotated segments beyond this count, 0 disables
+	gzip     bool          // gzip rotated segments
+}
+
+// rotatingWriter wraps a *csv.Writer for a single service, rotating the
+// underlying file to a timestamped segment (<name>-YYYYMMDD-HHMMSS-NNN.csv,
+// NNN disambiguating rotations within the same second) once it crosses the
+// retentionPolicy's size or age threshold, and pruning old segments outside
+// the retention window. Every new segment re-emits the header row so each
+// file is self-describing on its own.
+type rotatingWriter struct {
+	name   string
+	header []string
+	policy retentionPolicy
+
+	f          *os.File
+	w          *csv.Writer
+	size       int64
+	openedAt   time.Time
+	generation int // disambiguates segments that rotate within the same second
+}
+
+// newRotatingWriter creates a rotatingWriter that writes to name+".csv",
+// emitting header as the first record.
+func newRotatingWriter(name string, header []string, policy retentionPolicy) (*rotatingWriter, error) {
+	rw := &rotatingWriter{
+		name:   name,
+		header: header,
+		policy: policy,
+	}
+
+	if err := rw.openSegment(); err != nil {
+		return nil, err
+	}
+
+	return rw, nil
+}
+
+func (rw *rotatingWriter) openSegment() error {
+	f, err := os.Create(rw.name + ".csv")
+	if err != nil {
+		return err
+	}
+
+	rw.f = f
+	rw.w = csv.NewWriter(f)
+	rw.openedAt = time.Now()
+	rw.size = 0
+
+	return rw.writeRecord(rw.header)
+}
+
+// Write appends record, rotating the segment first if the retention policy
+// requires it.
+func (rw *rotatingWriter) Write(record []string) error {
+	if rw.shouldRotate() {
+		if err := rw.rotate(); err != nil {
+			return err
+		}
+	}
+
+	return rw.writeRecord(record)
+}
+
+func (rw *rotatingWriter) writeRecord(record []string) error {
+	if err := rw.w.Write(record); err != nil {
+		return err
+	}
+	rw.w.Flush()
+	if err := rw.w.Error(); err != nil {
+		return err
+	}
+
+	for _, field := range record {
+		rw.size += int64(len(field)) + 1
+	}
+
+	return nil
+}
+
+func (rw *rotatingWriter) shouldRotate() bool {
+	if rw.policy.maxSize > 0 && rw.size >= rw.policy.maxSize {
+		return true
+	}
+	if rw.policy.maxAge > 0 && time.Since(rw.openedAt) >= rw.policy.maxAge {
+		return true
+	}
+	return false
+}
+
+// rotate closes the current segment, renames it to a timestamped filename
+// (optionally gzipping it), opens a fresh segment, and prunes segments that
+// fall outside the retention window.
+func (rw *rotatingWriter) rotate() error {
+	rw.f.Close()
+
+	// The timestamp alone is only second-resolution; append the generation
+	// counter so two rotations within the same second don't clobber each
+	// other's segment.
+	stamp := time.Now().Format("20060102-150405")
+	rw.generation++
+	rotated := fmt.Sprintf("%s-%s-%03d.csv", rw.name, stamp, rw.generation)
+	if err := os.Rename(rw.name+".csv", rotated); err != nil {
+		return err
+	}
+
+	if rw.policy.gzip {
+		if err := gzipFile(rotated); err == nil {
+			os.Remove(rotated)
+		}
+	}
+
+	if err := rw.openSegment(); err != nil {
+		return err
+	}
+
+	return rw.prune()
+}
+
+// prune deletes rotated segments for this service that fall outside the
+// retention window: anything older than maxAge, and then, if there are
+// still more than maxFiles left, the oldest of what remains. Either limit
+// may be zero to disable that check.
+func (rw *rotatingWriter) prune() error {
+	if rw.policy.maxFiles <= 0 && rw.policy.maxAge <= 0 {
+		return nil
+	}
+
+	dir := filepath.Dir(rw.name)
+	if dir == "" {
+		dir = "."
+	}
+	base := filepath.Base(rw.name)
+	// Anchor to the full rotate() filename format, not just the base prefix:
+	// a bare base+"-" prefix also matches another service's segments whenever
+	// one service's name is a prefix of another's (e.g. "svc" vs "svc-worker").
+	segmentRE := regexp.MustCompile("^" + regexp.QuoteMeta(base) + `-\d{8}-\d{6}-\d{3}\.csv(\.gz)?$`)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var segments []string
+	for _, e := range entries {
+		n := e.Name()
+		if segmentRE.MatchString(n) {
+			segments = append(segments, filepath.Join(dir, n))
+		}
+	}
+
+	// oldest first: the timestamp suffix sorts lexically
+	sort.Strings(segments)
+
+	if rw.policy.maxAge > 0 {
+		cutoff := time.Now().Add(-rw.policy.maxAge)
+		var kept []string
+		for _, seg := range segments {
+			info, err := os.Stat(seg)
+			if err == nil && info.ModTime().Before(cutoff) {
+				os.Remove(seg)
+				continue
+			}
+			kept = append(kept, seg)
+		}
+		segments = kept
+	}
+
+	if rw.policy.maxFiles > 0 && len(segments) > rw.policy.maxFiles {
+		for _, seg := range segments[:len(segments)-rw.policy.maxFiles] {
+			os.Remove(seg)
+		}
+	}
+
+	return nil
+}
+
+// Close flushes and closes the current segment.
+func (rw *rotatingWriter) Close() error {
+	rw.w.Flush()
+	return rw.f.Close()
+}
+
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	gw := gzip.NewWriter(out)
+	if _, err := io.Copy(gw, in); err != nil {
+		gw.Close()
+		return err
+	}
+
+	return gw.Close()
+}
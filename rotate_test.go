@@ -0,0 +1,160 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseByteSize(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"100", 100, false},
+		{"1KB", 1 << 10, false},
+		{"100MB", 100 * (1 << 20), false},
+		{"2GB", 2 * (1 << 30), false},
+		{"bogus", 0, true},
+	}
+
+	for _, c := range cases {
+		got, err := parseByteSize(c.in)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("parseByteSize(%q): expected error, got %d", c.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseByteSize(%q): unexpected error: %v", c.in, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("parseByteSize(%q) = %d, want %d", c.in, got, c.want)
+		}
+	}
+}
+
+func TestRotatingWriterRotatesOnSize(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "svc")
+
+	rw, err := newRotatingWriter(name, []string{"time", "v"}, retentionPolicy{maxSize: 1})
+	if err != nil {
+		t.Fatalf("newRotatingWriter: %v", err)
+	}
+	defer rw.Close()
+
+	if err := rw.Write([]string{"t0", "1"}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := rw.Write([]string{"t1", "2"}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	matches, err := filepath.Glob(name + "-*.csv")
+	if err != nil {
+		t.Fatalf("Glob: %v", err)
+	}
+	if len(matches) == 0 {
+		t.Fatalf("expected at least one rotated segment, found none")
+	}
+
+	if _, err := os.Stat(name + ".csv"); err != nil {
+		t.Fatalf("expected live segment to exist: %v", err)
+	}
+}
+
+func TestRotatingWriterPrunesByMaxFiles(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "svc")
+
+	rw, err := newRotatingWriter(name, []string{"time"}, retentionPolicy{maxSize: 1, maxFiles: 1})
+	if err != nil {
+		t.Fatalf("newRotatingWriter: %v", err)
+	}
+	defer rw.Close()
+
+	for i := 0; i < 4; i++ {
+		if err := rw.Write([]string{"t"}); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		time.Sleep(time.Millisecond) // rotations in the same second share a timestamp; the generation suffix tells them apart
+	}
+
+	matches, err := filepath.Glob(name + "-*.csv")
+	if err != nil {
+		t.Fatalf("Glob: %v", err)
+	}
+	if len(matches) > 1 {
+		t.Errorf("expected at most 1 rotated segment with maxFiles=1, found %d: %v", len(matches), matches)
+	}
+}
+
+func TestRotatingWriterPrunesByMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "svc")
+
+	rw, err := newRotatingWriter(name, []string{"time"}, retentionPolicy{maxSize: 1, maxAge: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("newRotatingWriter: %v", err)
+	}
+	defer rw.Close()
+
+	// First write rotates the (empty) initial segment into the first
+	// rotated file on disk.
+	if err := rw.Write([]string{"t0"}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	firstGen, err := filepath.Glob(name + "-*.csv")
+	if err != nil {
+		t.Fatalf("Glob: %v", err)
+	}
+	if len(firstGen) != 1 {
+		t.Fatalf("expected exactly 1 rotated segment after the first rotation, got %v", firstGen)
+	}
+
+	// Once it's older than maxAge, the next rotation's prune pass should
+	// remove it, even though maxFiles is unset.
+	time.Sleep(10 * time.Millisecond)
+	if err := rw.Write([]string{"t1"}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	if _, err := os.Stat(firstGen[0]); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be pruned once past maxAge, stat err=%v", firstGen[0], err)
+	}
+}
+
+func TestRotatingWriterPruneDoesNotTouchPrefixedSibling(t *testing.T) {
+	dir := t.TempDir()
+
+	// "svc-worker"'s rotated segments must survive "svc"'s prune pass, even
+	// though "svc-worker" starts with "svc-".
+	sibling := filepath.Join(dir, "svc-worker-20260101-000000-001.csv")
+	if err := os.WriteFile(sibling, []byte("time\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	name := filepath.Join(dir, "svc")
+	rw, err := newRotatingWriter(name, []string{"time"}, retentionPolicy{maxSize: 1, maxFiles: 1})
+	if err != nil {
+		t.Fatalf("newRotatingWriter: %v", err)
+	}
+	defer rw.Close()
+
+	for i := 0; i < 4; i++ {
+		if err := rw.Write([]string{"t"}); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		time.Sleep(time.Millisecond) // rotations in the same second share a timestamp; the generation suffix tells them apart
+	}
+
+	if _, err := os.Stat(sibling); err != nil {
+		t.Errorf("expected %s to survive svc's prune pass, stat err=%v", sibling, err)
+	}
+}
@@ -1,9 +1,9 @@
 package main
 
 import (
-	"encoding/csv"
+	"context"
+	"log"
 	"net/url"
-	"os"
 	"strings"
 	"sync"
 	"time"
@@ -32,14 +32,90 @@ type Service struct {
 	Restarted     bool
 	UptimeCounter int64
 
-	// for serializing the data
-	// controlled by cmd option: serialize
-	f *os.File
-	w *csv.Writer // csv writer
+	// sinks receive a Snapshot after every poll cycle: the per-service CSV
+	// sink (-serialize) plus whatever -output sinks the caller passed into
+	// NewService.
+	sinks []Sink
+
+	// ownSinks is the subset of sinks this Service exclusively owns and must
+	// Close() itself (currently just the CSV sink, if any). The -output
+	// sinks passed into NewService are shared across every monitored
+	// Service, so they're deliberately excluded here: Close()'ing one from a
+	// service whose Update goroutine has stopped would tear it down out from
+	// under a different service still writing to it. Their owner — whoever
+	// built them and passed them into NewService — closes them exactly
+	// once, after every Service's Update goroutine has stopped.
+	ownSinks []Sink
+
+	inFlightMu sync.Mutex
+	inFlight   bool
+
+	// mu guards every field Update mutates (Err, Restarted, UptimeCounter,
+	// Cmdline, Name, stacks, lastPoll, pollTimes) against concurrent reads
+	// from the -http.listen control API and ShutdownCoordinator. This also
+	// covers the *Stack values stacks holds: Update pushes into them under
+	// mu, so readers must keep mu held for the duration of the Stack read
+	// too, not just the map lookup that finds it.
+	mu       sync.RWMutex
+	lastPoll time.Time
+
+	// pollTimes records one timestamp per completed Update call, trimmed to
+	// track the length of the stacks' own history so TimeSeries can pair
+	// each recorded value with the time it was observed.
+	pollTimes []time.Time
 }
 
-// NewService returns new Service object.
-func NewService(url url.URL, vars []VarName) *Service {
+// LastPoll returns the time of the most recently completed Update call.
+func (s *Service) LastPoll() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastPoll
+}
+
+// ServiceInfo is a point-in-time, race-free copy of a Service's identity and
+// status fields, for callers (the control API, ShutdownCoordinator) that run
+// concurrently with Update.
+type ServiceInfo struct {
+	Name          string
+	Cmdline       string
+	UptimeCounter int64
+	Restarted     bool
+	Err           error
+}
+
+// Info returns a ServiceInfo snapshot of s, safe to call while Update is
+// running concurrently in another goroutine.
+func (s *Service) Info() ServiceInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return ServiceInfo{
+		Name:          s.Name,
+		Cmdline:       s.Cmdline,
+		UptimeCounter: s.UptimeCounter,
+		Restarted:     s.Restarted,
+		Err:           s.Err,
+	}
+}
+
+// InFlight reports whether an Update call is currently running for this
+// service. Used by ShutdownCoordinator to report what it's still waiting on.
+func (s *Service) InFlight() bool {
+	s.inFlightMu.Lock()
+	defer s.inFlightMu.Unlock()
+	return s.inFlight
+}
+
+func (s *Service) setInFlight(v bool) {
+	s.inFlightMu.Lock()
+	s.inFlight = v
+	s.inFlightMu.Unlock()
+}
+
+// NewService returns new Service object. sinks are shared output sinks (e.g.
+// -output) the caller built once and is handing to every monitored Service
+// so they fan all services' snapshots into one Kafka/AMQP connection and one
+// InfluxDB flush ticker, rather than each Service opening its own.
+func NewService(url url.URL, vars []VarName, sinks []Sink) *Service {
 	//fmt.Printf("---new service: url:[%#v], vars:[%v]\n", url, vars)
 	values := make(map[VarName]*Stack)
 	for _, name := range vars {
@@ -51,44 +127,63 @@ func NewService(url url.URL, vars []VarName) *Service {
 		URL:    url,
 		stacks: values,
 		vars:   vars,
+		sinks:  append([]Sink(nil), sinks...),
 	}
 
 	if *serialize {
-		f, err := os.Create(s.Name + ".csv")
+		csvSink, err := NewCSVSink(s.Name, vars)
 		if err != nil {
 			panic(err)
 		}
-		s.f = f
-		s.w = csv.NewWriter(f)
-
-		// write first record: category line
-		record := []string{"time"}
-		for _, v := range vars {
-			record = append(record, string(v))
-		}
-		s.w.Write(record)
-		s.w.Flush()
+		s.sinks = append(s.sinks, csvSink)
+		s.ownSinks = append(s.ownSinks, csvSink)
 	}
 
 	return s
 }
 
-// Close does some cleanup before service exit
+// Close closes this Service's own sinks (currently just the CSV sink, if
+// -serialize is set). It deliberately leaves the shared -output sinks passed
+// into NewService alone — see ownSinks.
 func (s *Service) Close() {
-	if *serialize {
-		if s.f != nil {
-			s.f.Close()
+	for _, sink := range s.ownSinks {
+		if err := sink.Close(); err != nil {
+			log.Printf("%s: sink close failed: %v", s.Name, err)
 		}
 	}
 }
 
-// Update updates Service info from Expvar variable.
-func (s *Service) Update(wg *sync.WaitGroup) {
+// Update updates Service info from Expvar variable. ctx is checked before
+// the scrape starts so a cancelled polling loop doesn't kick off new work
+// while shutdown is draining in-flight calls.
+func (s *Service) Update(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
+
+	select {
+	case <-ctx.Done():
+		return
+	default:
+	}
+
+	s.setInFlight(true)
+	defer s.setInFlight(false)
+
 	expvar, err := FetchExpvar(s.URL)
+	if err != nil {
+		scrapeFailures.Add(1)
+	} else {
+		scrapeSuccesses.Add(1)
+	}
+
+	// Everything below mutates fields summary()/Value()/ShutdownCoordinator
+	// read concurrently (Err, Restarted, UptimeCounter, Cmdline, Name,
+	// stacks, lastPoll), so it all runs under a single write lock.
+	s.mu.Lock()
+
 	// check for restart
 	if s.Err != nil && err == nil {
 		s.Restarted = true
+		serviceRestarts.Add(1)
 	}
 	s.Err = err
 
@@ -100,6 +195,7 @@ func (s *Service) Update(wg *sync.WaitGroup) {
 	} else {
 		if s.UptimeCounter > c {
 			s.Restarted = true
+			serviceRestarts.Add(1)
 		}
 		s.UptimeCounter = c
 	}
@@ -127,16 +223,34 @@ func (s *Service) Update(wg *sync.WaitGroup) {
 			stack.Push(v)
 		}
 	}
+	s.lastPoll = time.Now()
+	s.pollTimes = append(s.pollTimes, s.lastPoll)
+	if len(s.vars) > 0 {
+		if n := len(s.stacks[s.vars[0]].IntValues()); n < len(s.pollTimes) {
+			s.pollTimes = s.pollTimes[len(s.pollTimes)-n:]
+		}
+	}
+	s.mu.Unlock()
+
+	if len(s.sinks) > 0 {
+		snap := Snapshot{
+			Time:    time.Now(),
+			Service: s.Name,
+			Host:    s.URL.Host,
+			Tags:    tag,
+			Values:  make(map[VarName]interface{}, len(s.vars)),
+		}
+		s.mu.RLock()
+		for name, stack := range s.stacks {
+			snap.Values[name] = stack.Front()
+		}
+		s.mu.RUnlock()
 
-	if *serialize {
-		// serialize the values  to csv
-		tm := time.Now().Format("2006-01-02 15:04:05")
-		values := []string{tm}
-		for _, name := range s.vars {
-			values = append(values, s.Value(name))
+		for _, sink := range s.sinks {
+			if err := sink.Write(snap); err != nil {
+				log.Printf("%s: sink write failed: %v", s.Name, err)
+			}
 		}
-		s.w.Write(values)
-		s.w.Flush()
 	}
 }
 
@@ -174,7 +288,10 @@ func guessValue(value *jason.Value) interface{} {
 // Value returns current value for the given var of this service.
 //
 // It also formats value, if kind is specified.
-func (s Service) Value(name VarName) string {
+func (s *Service) Value(name VarName) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	if s.Err != nil {
 		return "N/A"
 	}
@@ -193,7 +310,10 @@ func (s Service) Value(name VarName) string {
 
 // Values returns slice of ints with recent
 // values of the given var, to be used with sparkline.
-func (s Service) Values(name VarName) []int {
+func (s *Service) Values(name VarName) []int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	stack, ok := s.stacks[name]
 	if !ok {
 		return nil
@@ -202,8 +322,46 @@ func (s Service) Values(name VarName) []int {
 	return stack.IntValues()
 }
 
+// TimeSeriesPoint pairs a recorded value with the poll time it was observed
+// at.
+type TimeSeriesPoint struct {
+	Time  time.Time
+	Value int
+}
+
+// TimeSeries returns the recorded history for name as a slice of
+// (time, value) points, oldest first, for callers (the control API) that
+// need an actual time-series rather than the bare value slice Values
+// returns.
+func (s *Service) TimeSeries(name VarName) []TimeSeriesPoint {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stack, ok := s.stacks[name]
+	if !ok {
+		return nil
+	}
+	times := append([]time.Time(nil), s.pollTimes...)
+
+	values := stack.IntValues()
+	if len(times) > len(values) {
+		times = times[len(times)-len(values):]
+	} else if len(values) > len(times) {
+		values = values[len(values)-len(times):]
+	}
+
+	points := make([]TimeSeriesPoint, len(values))
+	for i, v := range values {
+		points[i] = TimeSeriesPoint{Time: times[i], Value: v}
+	}
+	return points
+}
+
 // Max returns maximum recorded value for given service and var.
-func (s Service) Max(name VarName) interface{} {
+func (s *Service) Max(name VarName) interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	val, ok := s.stacks[name]
 	if !ok {
 		return nil
@@ -216,3 +374,17 @@ func (s Service) Max(name VarName) interface{} {
 
 	return Format(v, name.Kind())
 }
+
+// Reset clears the Stack history and Max for every tracked var, leaving the
+// service's identity (Name, URL, Cmdline) and sinks untouched. Exposed via
+// POST /services/{name}/reset on the -http.listen control API, useful after
+// a deploy.
+func (s *Service) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name := range s.stacks {
+		s.stacks[name] = NewStack()
+	}
+	s.pollTimes = nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeSink is a test double recording how many times Close was called, so
+// tests can assert WaitForDeath's Close-vs-leave-open decisions without a
+// real file on disk.
+type fakeSink struct {
+	closed int32
+}
+
+func (f *fakeSink) Write(Snapshot) error { return nil }
+
+func (f *fakeSink) Close() error {
+	atomic.AddInt32(&f.closed, 1)
+	return nil
+}
+
+func newTestCoordinator(timeout time.Duration) *ShutdownCoordinator {
+	return &ShutdownCoordinator{
+		sig:     make(chan os.Signal, 1),
+		timeout: timeout,
+	}
+}
+
+func TestWaitForDeathCleanDrainClosesServices(t *testing.T) {
+	sink := &fakeSink{}
+	svc := &Service{Name: "clean", ownSinks: []Sink{sink}}
+
+	var wg sync.WaitGroup
+	_, cancel := context.WithCancel(context.Background())
+	sc := newTestCoordinator(time.Second)
+
+	sc.sig <- syscall.SIGINT
+
+	code := sc.WaitForDeath(cancel, &wg, []*Service{svc}, nil)
+
+	if code != 0 {
+		t.Errorf("code = %d, want 0 on a clean drain", code)
+	}
+	if atomic.LoadInt32(&sink.closed) != 1 {
+		t.Errorf("sink.closed = %d, want 1", sink.closed)
+	}
+}
+
+func TestWaitForDeathTimeoutLeavesInFlightServicesOpen(t *testing.T) {
+	inFlightSink := &fakeSink{}
+	inFlight := &Service{Name: "stuck", ownSinks: []Sink{inFlightSink}}
+	inFlight.setInFlight(true)
+
+	doneSink := &fakeSink{}
+	done := &Service{Name: "done", ownSinks: []Sink{doneSink}}
+
+	var wg sync.WaitGroup
+	wg.Add(1) // never Done(): simulates a scrape that won't finish in time
+
+	_, cancel := context.WithCancel(context.Background())
+	sc := newTestCoordinator(20 * time.Millisecond)
+
+	sc.sig <- syscall.SIGINT
+
+	code := sc.WaitForDeath(cancel, &wg, []*Service{inFlight, done}, nil)
+
+	if code != 1 {
+		t.Errorf("code = %d, want 1 on a timed-out shutdown", code)
+	}
+	if atomic.LoadInt32(&inFlightSink.closed) != 0 {
+		t.Errorf("in-flight service's sink was Close()'d during a timeout; "+
+			"its Update goroutine could still be writing to it, closed=%d", inFlightSink.closed)
+	}
+	if atomic.LoadInt32(&doneSink.closed) != 1 {
+		t.Errorf("done.sink.closed = %d, want 1: services that aren't in flight should still be closed", doneSink.closed)
+	}
+}
+
+func TestWaitForDeathCleanDrainClosesSharedOutputSinksOnce(t *testing.T) {
+	outputSink := &fakeSink{}
+	svcA := &Service{Name: "a"}
+	svcB := &Service{Name: "b"}
+
+	var wg sync.WaitGroup
+	_, cancel := context.WithCancel(context.Background())
+	sc := newTestCoordinator(time.Second)
+
+	sc.sig <- syscall.SIGINT
+
+	code := sc.WaitForDeath(cancel, &wg, []*Service{svcA, svcB}, []Sink{outputSink})
+
+	if code != 0 {
+		t.Errorf("code = %d, want 0 on a clean drain", code)
+	}
+	if atomic.LoadInt32(&outputSink.closed) != 1 {
+		t.Errorf("outputSink.closed = %d, want exactly 1 even though 2 services share it", outputSink.closed)
+	}
+}
+
+func TestWaitForDeathTimeoutLeavesSharedOutputSinksOpen(t *testing.T) {
+	outputSink := &fakeSink{}
+	inFlight := &Service{Name: "stuck"}
+	inFlight.setInFlight(true)
+	done := &Service{Name: "done"}
+
+	var wg sync.WaitGroup
+	wg.Add(1) // never Done(): simulates a scrape that won't finish in time
+
+	_, cancel := context.WithCancel(context.Background())
+	sc := newTestCoordinator(20 * time.Millisecond)
+
+	sc.sig <- syscall.SIGINT
+
+	code := sc.WaitForDeath(cancel, &wg, []*Service{inFlight, done}, []Sink{outputSink})
+
+	if code != 1 {
+		t.Errorf("code = %d, want 1 on a timed-out shutdown", code)
+	}
+	if atomic.LoadInt32(&outputSink.closed) != 0 {
+		t.Errorf("shared output sink was Close()'d despite a service still in flight; "+
+			"its Update goroutine could still be writing to it, closed=%d", outputSink.closed)
+	}
+}
+
+func TestWaitForDeathCancelsContext(t *testing.T) {
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+	sc := newTestCoordinator(time.Second)
+
+	sc.sig <- syscall.SIGINT
+	sc.WaitForDeath(cancel, &wg, nil, nil)
+
+	select {
+	case <-ctx.Done():
+	default:
+		t.Error("expected the polling context to be cancelled after WaitForDeath returns")
+	}
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/streadway/amqp"
+)
+
+var (
+	output = flag.String("output", "", "comma-separated list of sink URLs to write scraped vars to, e.g. influxdb://host:8086/db,kafka://broker/topic")
+	tag    tagFlag
+)
+
+func init() {
+	tag = make(tagFlag)
+	flag.Var(&tag, "tag", "key=value tag attached to every -output snapshot; may be repeated")
+}
+
+// tagFlag collects repeated -tag key=value pairs into a map, the way
+// repeated flag.Value flags are conventionally handled.
+type tagFlag map[string]string
+
+func (t tagFlag) String() string {
+	parts := make([]string, 0, len(t))
+	for k, v := range t {
+		parts = append(parts, k+"="+v)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (t tagFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("tag: expected key=value, got %q", s)
+	}
+	t[k] = v
+	return nil
+}
+
+// Snapshot is a single, timestamped reading of all tracked vars for one
+// service, handed to every configured Sink after a poll cycle.
+type Snapshot struct {
+	Time    time.Time
+	Service string
+	Host    string
+	Tags    map[string]string
+	Values  map[VarName]interface{}
+}
+
+// Sink receives Snapshots produced by Service.Update. Implementations must
+// be safe to call from the polling goroutines.
+type Sink interface {
+	Write(snap Snapshot) error
+	Close() error
+}
+
+// NewSinks builds the list of Sinks described by raw, a comma-separated list
+// of destination URLs as passed to -output (e.g.
+// "influxdb://host:8086/db,kafka://broker/topic"). tags are the user-supplied
+// -tag key=value pairs, attached to every snapshot handed to sinks that
+// support tagging.
+//
+// Call this once per process and pass the resulting slice into every
+// NewService call, so all monitored services fan their snapshots into one
+// Kafka/AMQP connection and one InfluxDB flush ticker instead of each
+// opening its own to the same destination.
+func NewSinks(raw string, tags map[string]string) ([]Sink, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	var sinks []Sink
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		u, err := url.Parse(part)
+		if err != nil {
+			return nil, fmt.Errorf("output: invalid url %q: %v", part, err)
+		}
+
+		switch u.Scheme {
+		case "influxdb":
+			sinks = append(sinks, NewInfluxDBSink(u, tags))
+		case "kafka":
+			sinks = append(sinks, NewKafkaSink(u))
+		case "amqp":
+			sinks = append(sinks, NewAMQPSink(u))
+		default:
+			return nil, fmt.Errorf("output: unsupported scheme %q in %q", u.Scheme, part)
+		}
+	}
+
+	return sinks, nil
+}
+
+// CSVSink writes snapshots as CSV rows, one file per service, rotating and
+// pruning segments per the -serialize.max-* retention policy.
+type CSVSink struct {
+	vars []VarName
+	w    *rotatingWriter
+}
+
+// NewCSVSink creates a CSVSink that writes to name+".csv", emitting a header
+// row listing vars. It rotates per retentionPolicyFromFlags.
+func NewCSVSink(name string, vars []VarName) (*CSVSink, error) {
+	policy, err := retentionPolicyFromFlags()
+	if err != nil {
+		return nil, err
+	}
+
+	header := []string{"time"}
+	for _, v := range vars {
+		header = append(header, string(v))
+	}
+
+	w, err := newRotatingWriter(name, header, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CSVSink{vars: vars, w: w}, nil
+}
+
+// Write implements Sink.
+func (s *CSVSink) Write(snap Snapshot) error {
+	record := []string{snap.Time.Format("2006-01-02 15:04:05")}
+	for _, name := range s.vars {
+		v, ok := snap.Values[name]
+		if !ok || v == nil {
+			record = append(record, "N/A")
+			continue
+		}
+		record = append(record, Format(v, name.Kind()))
+	}
+	return s.w.Write(record)
+}
+
+// Close implements Sink.
+func (s *CSVSink) Close() error {
+	return s.w.Close()
+}
+
+// InfluxDBSink writes snapshots to InfluxDB using the line protocol over
+// HTTP, batching points and flushing them on a timer the way telegraf's
+// output plugins do.
+type InfluxDBSink struct {
+	addr string
+	db   string
+	tags map[string]string
+
+	flushInterval time.Duration
+	client        *http.Client
+
+	mu     sync.Mutex
+	points []string
+	done   chan struct{}
+	wg     sync.WaitGroup
+
+	// closeOnce guards Close: one instance is shared by every monitored
+	// Service, and a second Close must not close s.done again, which would
+	// panic.
+	closeOnce sync.Once
+}
+
+// NewInfluxDBSink builds an InfluxDBSink from an "influxdb://host:port/db"
+// URL, flushing batched points every flushInterval (defaults to 10s).
+func NewInfluxDBSink(u *url.URL, tags map[string]string) *InfluxDBSink {
+	s := &InfluxDBSink{
+		addr:          u.Host,
+		db:            strings.TrimPrefix(u.Path, "/"),
+		tags:          tags,
+		flushInterval: influxDBFlushInterval(u),
+		client:        &http.Client{Timeout: 5 * time.Second},
+		done:          make(chan struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s
+}
+
+func influxDBFlushInterval(u *url.URL) time.Duration {
+	if d := u.Query().Get("flush_interval"); d != "" {
+		if parsed, err := time.ParseDuration(d); err == nil {
+			return parsed
+		}
+	}
+	return 10 * time.Second
+}
+
+func (s *InfluxDBSink) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.flush()
+		case <-s.done:
+			s.flush()
+			return
+		}
+	}
+}
+
+// Write implements Sink. It appends a measurement in line protocol; the
+// actual HTTP write happens on the next flush tick.
+func (s *InfluxDBSink) Write(snap Snapshot) error {
+	var tags strings.Builder
+	tags.WriteString("host=" + escapeTag(snap.Host))
+	tags.WriteString(",cmdline_basename=" + escapeTag(snap.Service))
+	for k, v := range s.tags {
+		tags.WriteString("," + escapeTag(k) + "=" + escapeTag(v))
+	}
+
+	var fields strings.Builder
+	first := true
+	for name, v := range snap.Values {
+		if v == nil {
+			continue
+		}
+		if !first {
+			fields.WriteString(",")
+		}
+		first = false
+		fields.WriteString(string(name) + "=" + influxFieldValue(v))
+	}
+	if first {
+		// no fields, nothing to emit for this service on this tick
+		return nil
+	}
+
+	line := fmt.Sprintf("%s,%s %s %d", snap.Service, tags.String(), fields.String(), snap.Time.UnixNano())
+
+	s.mu.Lock()
+	s.points = append(s.points, line)
+	s.mu.Unlock()
+
+	return nil
+}
+
+func influxFieldValue(v interface{}) string {
+	switch n := v.(type) {
+	case int64:
+		return strconv.FormatInt(n, 10) + "i"
+	case float64:
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(n)
+	default:
+		return fmt.Sprintf("%q", fmt.Sprint(n))
+	}
+}
+
+func escapeTag(s string) string {
+	s = strings.ReplaceAll(s, " ", "\\ ")
+	s = strings.ReplaceAll(s, ",", "\\,")
+	return strings.ReplaceAll(s, "=", "\\=")
+}
+
+// flush POSTs the batched points to InfluxDB's /write endpoint. On any
+// failure to send or persist the batch, it logs the error rather than
+// retrying: points are already popped from s.points by the time the request
+// is attempted, so a failed flush drops that batch rather than blocking or
+// reordering later ones.
+func (s *InfluxDBSink) flush() {
+	s.mu.Lock()
+	points := s.points
+	s.points = nil
+	s.mu.Unlock()
+
+	if len(points) == 0 {
+		return
+	}
+
+	body := bytes.NewBufferString(strings.Join(points, "\n"))
+	endpoint := fmt.Sprintf("http://%s/write?db=%s", s.addr, url.QueryEscape(s.db))
+	resp, err := s.client.Post(endpoint, "text/plain", body)
+	if err != nil {
+		log.Printf("influxdb sink: flush to %s failed, dropping %d point(s): %v", s.addr, len(points), err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		log.Printf("influxdb sink: flush to %s returned %s, dropping %d point(s)", s.addr, resp.Status, len(points))
+	}
+}
+
+// Close implements Sink.
+func (s *InfluxDBSink) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+	})
+	return nil
+}
+
+// KafkaSink publishes each snapshot as a JSON message to a Kafka topic.
+type KafkaSink struct {
+	topic    string
+	producer sarama.SyncProducer
+
+	// closeOnce guards Close: one instance is shared by every monitored
+	// Service, and a second Close must not close the producer again, which
+	// is not safe.
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewKafkaSink builds a KafkaSink from a "kafka://broker/topic" URL.
+func NewKafkaSink(u *url.URL) *KafkaSink {
+	cfg := sarama.NewConfig()
+	cfg.Producer.Return.Successes = true
+
+	producer, err := sarama.NewSyncProducer([]string{u.Host}, cfg)
+	if err != nil {
+		// the error surfaces on the first Write instead of at flag-parsing
+		// time, matching how the other sinks fail lazily on I/O.
+		return &KafkaSink{topic: strings.TrimPrefix(u.Path, "/")}
+	}
+
+	return &KafkaSink{
+		topic:    strings.TrimPrefix(u.Path, "/"),
+		producer: producer,
+	}
+}
+
+// Write implements Sink.
+func (s *KafkaSink) Write(snap Snapshot) error {
+	if s.producer == nil {
+		return fmt.Errorf("kafka sink: no producer available for topic %q", s.topic)
+	}
+
+	body, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = s.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: s.topic,
+		Value: sarama.ByteEncoder(body),
+	})
+	return err
+}
+
+// Close implements Sink.
+func (s *KafkaSink) Close() error {
+	s.closeOnce.Do(func() {
+		if s.producer != nil {
+			s.closeErr = s.producer.Close()
+		}
+	})
+	return s.closeErr
+}
+
+// AMQPSink publishes each snapshot as a JSON message to an AMQP exchange.
+type AMQPSink struct {
+	exchange string
+
+	conn *amqp.Connection
+	ch   *amqp.Channel
+
+	// closeOnce guards Close: one instance is shared by every monitored
+	// Service, and a second Close must not close the channel/connection
+	// again, which is not safe.
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewAMQPSink builds an AMQPSink from an "amqp://host/exchange" URL.
+func NewAMQPSink(u *url.URL) *AMQPSink {
+	exchange := strings.TrimPrefix(u.Path, "/")
+
+	conn, err := amqp.Dial(fmt.Sprintf("amqp://%s/", u.Host))
+	if err != nil {
+		return &AMQPSink{exchange: exchange}
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return &AMQPSink{exchange: exchange}
+	}
+
+	return &AMQPSink{exchange: exchange, conn: conn, ch: ch}
+}
+
+// Write implements Sink.
+func (s *AMQPSink) Write(snap Snapshot) error {
+	if s.ch == nil {
+		return fmt.Errorf("amqp sink: no channel available for exchange %q", s.exchange)
+	}
+
+	body, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	return s.ch.Publish(s.exchange, "", false, false, amqp.Publishing{
+		ContentType: "application/json",
+		Body:        body,
+	})
+}
+
+// Close implements Sink.
+func (s *AMQPSink) Close() error {
+	s.closeOnce.Do(func() {
+		if s.ch != nil {
+			s.ch.Close()
+		}
+		if s.conn != nil {
+			s.closeErr = s.conn.Close()
+		}
+	})
+	return s.closeErr
+}
@@ -0,0 +1,119 @@
+package main
+
+import "testing"
+
+func TestNewSinksDispatchesByScheme(t *testing.T) {
+	cases := []struct {
+		name    string
+		raw     string
+		wantLen int
+		wantErr bool
+	}{
+		{"empty", "", 0, false},
+		{"blank entries are skipped", " , ,", 0, false},
+		{"single influxdb sink", "influxdb://localhost:8086/mydb", 1, false},
+		{"single kafka sink", "kafka://localhost:9092/mytopic", 1, false},
+		{"single amqp sink", "amqp://localhost/myexchange", 1, false},
+		{"multiple sinks", "influxdb://localhost:8086/mydb,kafka://localhost:9092/mytopic", 2, false},
+		{"unsupported scheme", "foo://localhost", 0, true},
+		{"invalid url", "://bad", 0, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sinks, err := NewSinks(c.raw, nil)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("NewSinks(%q) = nil error, want one", c.raw)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewSinks(%q) = unexpected error: %v", c.raw, err)
+			}
+			if len(sinks) != c.wantLen {
+				t.Errorf("NewSinks(%q) returned %d sink(s), want %d", c.raw, len(sinks), c.wantLen)
+			}
+		})
+	}
+}
+
+func TestTagFlagSet(t *testing.T) {
+	cases := []struct {
+		in      string
+		wantKey string
+		wantVal string
+		wantErr bool
+	}{
+		{"env=prod", "env", "prod", false},
+		{"region=us-east-1", "region", "us-east-1", false},
+		{"novalue", "", "", true},
+	}
+
+	for _, c := range cases {
+		tag := make(tagFlag)
+		err := tag.Set(c.in)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("tag.Set(%q) = nil error, want one", c.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("tag.Set(%q) = unexpected error: %v", c.in, err)
+			continue
+		}
+		if got := tag[c.wantKey]; got != c.wantVal {
+			t.Errorf("tag.Set(%q): tag[%q] = %q, want %q", c.in, c.wantKey, got, c.wantVal)
+		}
+	}
+}
+
+func TestTagFlagString(t *testing.T) {
+	tag := make(tagFlag)
+	if got := tag.String(); got != "" {
+		t.Errorf("empty tagFlag.String() = %q, want \"\"", got)
+	}
+
+	tag.Set("env=prod")
+	if got := tag.String(); got != "env=prod" {
+		t.Errorf("tagFlag.String() = %q, want %q", got, "env=prod")
+	}
+}
+
+func TestEscapeTag(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{"simple", "simple"},
+		{"has space", `has\ space`},
+		{"a,b", `a\,b`},
+		{"k=v", `k\=v`},
+		{"a b,c=d", `a\ b\,c\=d`},
+	}
+
+	for _, c := range cases {
+		if got := escapeTag(c.in); got != c.want {
+			t.Errorf("escapeTag(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestInfluxFieldValue(t *testing.T) {
+	cases := []struct {
+		in   interface{}
+		want string
+	}{
+		{int64(42), "42i"},
+		{float64(3.5), "3.5"},
+		{true, "true"},
+		{false, "false"},
+		{"hello", `"hello"`},
+	}
+
+	for _, c := range cases {
+		if got := influxFieldValue(c.in); got != c.want {
+			t.Errorf("influxFieldValue(%#v) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}